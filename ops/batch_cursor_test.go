@@ -0,0 +1,59 @@
+package ops
+
+import (
+	"context"
+	"testing"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+func TestBatchCursor_NextBatch_ReturnsQueuedInitialBatchRaw(t *testing.T) {
+	initial := []bson.Raw{rawDoc(t, bson.D{{Name: "x", Value: 1}}), rawDoc(t, bson.D{{Name: "x", Value: 2}})}
+	bc := &BatchCursor{queuedBatch: initial}
+
+	if !bc.NextBatch(context.Background()) {
+		t.Fatalf("expected the queued initial batch to be available, err=%v", bc.Err())
+	}
+	if len(bc.Batch()) != len(initial) {
+		t.Fatalf("expected Batch() to expose the raw initial batch untouched, got %d docs", len(bc.Batch()))
+	}
+	if bc.queuedBatch != nil {
+		t.Fatalf("expected queuedBatch to be consumed after NextBatch")
+	}
+}
+
+// TestBatchCursor_NextBatch_ExhaustedWithoutGetMore ensures a cursor that reports cursorId 0 after
+// its initial batch never attempts a getMore, since there is nothing left on the server to fetch.
+func TestBatchCursor_NextBatch_ExhaustedWithoutGetMore(t *testing.T) {
+	bc := &BatchCursor{queuedBatch: []bson.Raw{rawDoc(t, bson.D{{Name: "x", Value: 1}})}}
+
+	if !bc.NextBatch(context.Background()) {
+		t.Fatalf("expected the initial batch to be returned")
+	}
+	if bc.NextBatch(context.Background()) {
+		t.Fatalf("expected NextBatch to report exhaustion once cursorId is 0")
+	}
+	if bc.Err() != nil {
+		t.Fatalf("exhaustion is not an error: %v", bc.Err())
+	}
+}
+
+func TestBatchCursor_PostBatchResumeToken(t *testing.T) {
+	bc := &BatchCursor{}
+	if bc.PostBatchResumeToken().Data != nil {
+		t.Fatalf("expected a zero PostBatchResumeToken before any getMore")
+	}
+
+	token := rawDoc(t, bson.D{{Name: "_data", Value: "abc"}})
+	bc.postBatchResumeToken = token
+	if bc.PostBatchResumeToken().Data == nil {
+		t.Fatalf("expected PostBatchResumeToken to return the most recent token")
+	}
+}
+
+func TestBatchCursor_Close_AlreadyExhaustedIsNoop(t *testing.T) {
+	bc := &BatchCursor{}
+	if err := bc.Close(context.Background()); err != nil {
+		t.Fatalf("closing an already-exhausted cursor should not error: %v", err)
+	}
+}
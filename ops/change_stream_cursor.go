@@ -0,0 +1,319 @@
+package ops
+
+import (
+	"context"
+
+	"github.com/10gen/mongo-go-driver/core"
+	"github.com/10gen/mongo-go-driver/core/msg"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// resumableChangeStreamErrorCodes are the server error codes that mean a change stream's cursor
+// was invalidated by something transient (e.g. an election) rather than a real failure, so the
+// driver should transparently resume rather than surface the error to the caller.
+var resumableChangeStreamErrorCodes = map[int32]bool{
+	6:     true, // HostUnreachable
+	7:     true, // HostNotFound
+	89:    true, // NetworkTimeout
+	91:    true, // ShutdownInProgress
+	189:   true, // PrimarySteppedDown
+	262:   true, // ExceededTimeLimit
+	9001:  true, // SocketException
+	10107: true, // NotMaster
+	11600: true, // InterruptedAtShutdown
+	11602: true, // InterruptedDueToReplStateChange
+	13435: true, // NotMasterNoSlaveOk
+	13436: true, // NotMasterOrSecondary
+	63:    true, // StaleShardVersion
+	150:   true, // StaleEpoch
+	13388: true, // StaleConfig
+	234:   true, // RetryChangeStream
+	133:   true, // FailedToSatisfyReadPreference
+}
+
+// ChangeStreamCursor wraps a BatchCursor to consume a $changeStream aggregation. It tracks the
+// postBatchResumeToken from each getMore response (falling back to the _id of the last document
+// in the batch when the server doesn't send one) and transparently re-issues the originating
+// aggregate pipeline with resumeAfter/startAfter when a resumable error occurs, so callers never
+// see the transient failure and never re-observe an event they've already iterated.
+type ChangeStreamCursor struct {
+	bc          *BatchCursor
+	registry    Registry
+	current     int
+	err         error
+	resumeToken bson.Raw
+	startAfter  bool
+
+	namespace         Namespace
+	changeStreamStage bson.D
+	restPipeline      []bson.Raw
+	server            Server
+	opts              *CursorOptions
+}
+
+// NewChangeStreamCursor creates a ChangeStreamCursor from the result of an already-issued
+// $changeStream aggregate. changeStreamStage is the options document passed to $changeStream
+// (without resumeAfter/startAfter, which the cursor manages itself); restPipeline is any stages
+// following $changeStream. Both are retained so the cursor can rebuild the aggregate after a
+// resumable error. opts may be nil.
+func NewChangeStreamCursor(
+	ctx context.Context,
+	cursorResult CursorResult,
+	changeStreamStage bson.D,
+	restPipeline []bson.Raw,
+	server Server,
+	opts *CursorOptions,
+) (*ChangeStreamCursor, error) {
+	bc, err := NewBatchCursor(ctx, cursorResult, server, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ChangeStreamCursor{
+		bc:                bc,
+		registry:          DefaultRegistry,
+		namespace:         cursorResult.Namespace(),
+		changeStreamStage: changeStreamStage,
+		restPipeline:      restPipeline,
+		server:            server,
+		opts:              opts,
+	}, nil
+}
+
+// ResumeToken returns the token for the last event this cursor surfaced, suitable for passing as
+// resumeAfter (or startAfter, see StartAfter) to start a new change stream from this point.
+func (cs *ChangeStreamCursor) ResumeToken() bson.Raw {
+	return cs.resumeToken
+}
+
+// StartAfter selects whether this cursor resumes with startAfter instead of resumeAfter.
+// startAfter, unlike resumeAfter, can restart from a token pointing at an invalidate event,
+// which is what callers resuming from a saved token (rather than an error this cursor hit itself)
+// typically want. The choice applies to every resume this cursor performs, not only the first.
+func (cs *ChangeStreamCursor) StartAfter(startAfter bool) {
+	cs.startAfter = startAfter
+}
+
+func (cs *ChangeStreamCursor) Next(ctx context.Context, result interface{}) bool {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	for {
+		if cs.decodeNext(result) {
+			return true
+		}
+		if cs.err != nil {
+			return false
+		}
+		if !cs.advance(ctx) {
+			return false
+		}
+	}
+}
+
+func (cs *ChangeStreamCursor) TryNext(ctx context.Context, result interface{}) bool {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	if cs.decodeNext(result) {
+		return true
+	}
+	if cs.err != nil {
+		return false
+	}
+	if !cs.advance(ctx) {
+		return false
+	}
+
+	return cs.decodeNext(result)
+}
+
+func (cs *ChangeStreamCursor) Err() error {
+	return cs.err
+}
+
+func (cs *ChangeStreamCursor) Close(ctx context.Context) error {
+	return cs.bc.Close(ctx)
+}
+
+func (cs *ChangeStreamCursor) decodeNext(result interface{}) bool {
+	batch := cs.bc.Batch()
+	if cs.current < len(batch) {
+		if err := cs.registry.Decode(batch[cs.current], result); err != nil {
+			cs.err = err
+			return false
+		}
+		cs.current++
+		if cs.current == len(batch) {
+			cs.updateResumeToken()
+		} else {
+			var event struct {
+				ID bson.Raw `bson:"_id"`
+			}
+			if err := bson.Unmarshal(batch[cs.current-1].Data, &event); err == nil {
+				cs.resumeToken = event.ID
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// advance fetches the next batch, resuming the underlying aggregate if the server reports a
+// resumable error. It returns false with Err() unset once the cursor is exhausted, and false with
+// Err() set on a non-resumable error or a failed resume.
+func (cs *ChangeStreamCursor) advance(ctx context.Context) bool {
+	if cs.bc.NextBatch(ctx) {
+		cs.updateResumeToken()
+		cs.current = 0
+		return true
+	}
+
+	err := cs.bc.Err()
+	if err == nil {
+		return false
+	}
+	if !isResumableChangeStreamError(err) {
+		cs.err = err
+		return false
+	}
+
+	if err := cs.resume(ctx); err != nil {
+		cs.err = err
+		return false
+	}
+
+	// The resumed aggregate's firstBatch is sitting in cs.bc's queuedBatch; promote it to
+	// currentBatch (and update the resume token from it) the same way the non-resume path does,
+	// rather than leaving Batch() empty until some later call happens to advance again.
+	return cs.advance(ctx)
+}
+
+func (cs *ChangeStreamCursor) updateResumeToken() {
+	if token := cs.bc.PostBatchResumeToken(); token.Data != nil {
+		cs.resumeToken = token
+		return
+	}
+
+	batch := cs.bc.Batch()
+	if len(batch) == 0 {
+		return
+	}
+
+	var lastEvent struct {
+		ID bson.Raw `bson:"_id"`
+	}
+	if err := bson.Unmarshal(batch[len(batch)-1].Data, &lastEvent); err == nil {
+		cs.resumeToken = lastEvent.ID
+	}
+}
+
+// resume re-issues the originating aggregate with resumeAfter (or startAfter) set to the last
+// known resume token and swaps in the fresh BatchCursor it gets back.
+func (cs *ChangeStreamCursor) resume(ctx context.Context) error {
+	cursor := bson.D{}
+	if cs.opts != nil && cs.opts.BatchSize != 0 {
+		cursor = append(cursor, bson.DocElem{Name: "batchSize", Value: cs.opts.BatchSize})
+	}
+
+	aggregateCommand := struct {
+		Collection string        `bson:"aggregate"`
+		Pipeline   []interface{} `bson:"pipeline"`
+		Cursor     bson.D        `bson:"cursor"`
+	}{
+		Collection: cs.namespace.Collection,
+		Pipeline:   cs.buildPipeline(),
+		Cursor:     cursor,
+	}
+
+	aggregateRequest := msg.NewCommand(
+		msg.NextRequestID(),
+		cs.namespace.DB,
+		false,
+		aggregateCommand,
+	)
+
+	var response struct {
+		OK     bool `bson:"ok"`
+		Cursor struct {
+			FirstBatch []bson.Raw `bson:"firstBatch"`
+			NS         string     `bson:"ns"`
+			ID         int64      `bson:"id"`
+		} `bson:"cursor"`
+	}
+
+	conn, err := cs.server.Connection(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if err := executeCommand(ctx, conn, aggregateRequest, &response); err != nil {
+		return err
+	}
+
+	bc, err := NewBatchCursor(ctx, &changeStreamResumeResult{
+		namespace:    cs.namespace,
+		initialBatch: response.Cursor.FirstBatch,
+		cursorId:     response.Cursor.ID,
+	}, cs.server, cs.opts)
+	if err != nil {
+		return err
+	}
+
+	// The old BatchCursor's cursorId on the server is gone the moment the aggregate above ran (a
+	// resumable error already invalidated it), but if this cursor was pinned, the connection it
+	// holds is still checked out and must be returned to the pool; otherwise every resume leaks
+	// one connection for good.
+	cs.bc.Close(ctx)
+	cs.bc = bc
+	return nil
+}
+
+func (cs *ChangeStreamCursor) buildPipeline() []interface{} {
+	stage := make(bson.D, 0, len(cs.changeStreamStage)+1)
+	for _, elem := range cs.changeStreamStage {
+		if elem.Name == "resumeAfter" || elem.Name == "startAfter" {
+			continue
+		}
+		stage = append(stage, elem)
+	}
+
+	if cs.resumeToken.Data != nil {
+		key := "resumeAfter"
+		if cs.startAfter {
+			key = "startAfter"
+		}
+		stage = append(stage, bson.DocElem{Name: key, Value: cs.resumeToken})
+	}
+
+	pipeline := make([]interface{}, 0, len(cs.restPipeline)+1)
+	pipeline = append(pipeline, bson.D{{Name: "$changeStream", Value: stage}})
+	for _, raw := range cs.restPipeline {
+		pipeline = append(pipeline, raw)
+	}
+
+	return pipeline
+}
+
+func isResumableChangeStreamError(err error) bool {
+	cmdErr, ok := err.(core.CommandError)
+	if !ok {
+		return false
+	}
+	return resumableChangeStreamErrorCodes[cmdErr.Code]
+}
+
+// changeStreamResumeResult adapts the result of a resume aggregate to the CursorResult interface
+// so it can be handed to NewBatchCursor like any other cursor-returning command.
+type changeStreamResumeResult struct {
+	namespace    Namespace
+	initialBatch []bson.Raw
+	cursorId     int64
+}
+
+func (r *changeStreamResumeResult) Namespace() Namespace     { return r.namespace }
+func (r *changeStreamResumeResult) InitialBatch() []bson.Raw { return r.initialBatch }
+func (r *changeStreamResumeResult) CursorId() int64          { return r.cursorId }
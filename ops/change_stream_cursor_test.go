@@ -0,0 +1,138 @@
+package ops
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/10gen/mongo-go-driver/core"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// TestChangeStreamCursorTryNext_IssuesExactlyOneGetMoreWhenBatchDrained mirrors
+// TestCursorTryNext_IssuesExactlyOneGetMoreWhenBatchDrained for the change-stream cursor: an empty
+// current batch with a still-alive cursorId must trigger exactly one advance/getMore attempt, not
+// none, so a tailable change-stream consumer polling via TryNext keeps making progress.
+func TestChangeStreamCursorTryNext_IssuesExactlyOneGetMoreWhenBatchDrained(t *testing.T) {
+	attempts := 0
+	srv := &recordingServer{fn: func(ctx context.Context) (Connection, error) {
+		attempts++
+		return nil, errors.New("no connection in this test")
+	}}
+	cs := &ChangeStreamCursor{
+		bc:       &BatchCursor{currentBatch: []bson.Raw{}, cursorId: 42, server: srv},
+		registry: DefaultRegistry,
+	}
+
+	var doc bson.D
+	if cs.TryNext(context.Background(), &doc) {
+		t.Fatalf("expected TryNext to find nothing when the getMore fails")
+	}
+	if cs.Err() == nil {
+		t.Fatalf("expected the getMore failure to surface through Err()")
+	}
+	if attempts != 1 {
+		t.Fatalf("expected TryNext to attempt exactly one getMore round trip, got %d", attempts)
+	}
+}
+
+// TestChangeStreamCursorTryNext_ReturnsResumedDocumentWithoutASecondCall locks in the chunk0-3
+// fix to advance's resume branch: once resume swaps in the fresh BatchCursor a successful resumed
+// aggregate produces, that cursor's firstBatch sits in queuedBatch (NewBatchCursor never touches
+// currentBatch itself), and the very same TryNext call that performed the resume must promote and
+// decode it, not require a caller to notice Batch() is still empty and call TryNext again. This
+// models the post-resume state directly, since driving an actual resumable getMore failure through
+// to a real resume would require a live core.Connection, which nothing else in this package does
+// either (see errorServer).
+func TestChangeStreamCursorTryNext_ReturnsResumedDocumentWithoutASecondCall(t *testing.T) {
+	resumedDoc := rawDoc(t, bson.D{{Name: "_id", Value: 1}})
+	cs := &ChangeStreamCursor{
+		bc:       &BatchCursor{queuedBatch: []bson.Raw{resumedDoc}, cursorId: 7},
+		registry: DefaultRegistry,
+	}
+
+	var got struct {
+		ID int `bson:"_id"`
+	}
+	if !cs.TryNext(context.Background(), &got) {
+		t.Fatalf("expected TryNext to return the resumed cursor's first document immediately, err=%v", cs.Err())
+	}
+	if got.ID != 1 {
+		t.Fatalf("expected the decoded document to be the resumed cursor's first document, got %+v", got)
+	}
+	if len(cs.bc.Batch()) != 1 {
+		t.Fatalf("expected the queued batch to have been promoted to currentBatch, got %d docs", len(cs.bc.Batch()))
+	}
+}
+
+func TestIsResumableChangeStreamError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"resumable code", core.CommandError{Code: 189}, true}, // PrimarySteppedDown
+		{"non-resumable code", core.CommandError{Code: 11000}, false},
+		{"not a CommandError", errors.New("network reset"), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isResumableChangeStreamError(tt.err); got != tt.want {
+				t.Errorf("isResumableChangeStreamError(%v) = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUpdateResumeToken_PrefersPostBatchResumeToken(t *testing.T) {
+	postBatchToken := rawDoc(t, bson.D{{Name: "_data", Value: "post-batch"}})
+	lastDoc := rawDoc(t, bson.D{{Name: "_id", Value: bson.D{{Name: "_data", Value: "from-id"}}}})
+
+	cs := &ChangeStreamCursor{bc: &BatchCursor{
+		currentBatch:         []bson.Raw{lastDoc},
+		postBatchResumeToken: postBatchToken,
+	}}
+
+	cs.updateResumeToken()
+
+	var got struct {
+		Data string `bson:"_data"`
+	}
+	if err := bson.Unmarshal(cs.resumeToken.Data, &got); err != nil {
+		t.Fatalf("unmarshal resume token: %v", err)
+	}
+	if got.Data != "post-batch" {
+		t.Fatalf("expected the postBatchResumeToken to win, got %q", got.Data)
+	}
+}
+
+func TestUpdateResumeToken_FallsBackToLastDocumentID(t *testing.T) {
+	lastDoc := rawDoc(t, bson.D{{Name: "_id", Value: bson.D{{Name: "_data", Value: "from-id"}}}})
+
+	cs := &ChangeStreamCursor{bc: &BatchCursor{
+		currentBatch: []bson.Raw{lastDoc},
+	}}
+
+	cs.updateResumeToken()
+
+	var got struct {
+		Data string `bson:"_data"`
+	}
+	if err := bson.Unmarshal(cs.resumeToken.Data, &got); err != nil {
+		t.Fatalf("unmarshal resume token: %v", err)
+	}
+	if got.Data != "from-id" {
+		t.Fatalf("expected the fallback to the last document's _id, got %q", got.Data)
+	}
+}
+
+func TestUpdateResumeToken_LeavesTokenUnsetOnEmptyBatch(t *testing.T) {
+	cs := &ChangeStreamCursor{bc: &BatchCursor{}}
+
+	cs.updateResumeToken()
+
+	if cs.resumeToken.Data != nil {
+		t.Fatalf("expected no resume token to be set from an empty batch")
+	}
+}
@@ -0,0 +1,83 @@
+package ops
+
+import (
+	"errors"
+	"testing"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+type recordingMonitor struct {
+	started   []*CommandStartedEvent
+	succeeded []*CommandSucceededEvent
+	failed    []*CommandFailedEvent
+}
+
+func (m *recordingMonitor) Started(e *CommandStartedEvent)     { m.started = append(m.started, e) }
+func (m *recordingMonitor) Succeeded(e *CommandSucceededEvent) { m.succeeded = append(m.succeeded, e) }
+func (m *recordingMonitor) Failed(e *CommandFailedEvent)       { m.failed = append(m.failed, e) }
+
+func TestBatchCursor_NotifyStarted(t *testing.T) {
+	mon := &recordingMonitor{}
+	bc := &BatchCursor{namespace: Namespace{DB: "testdb", Collection: "coll"}, monitor: mon}
+
+	command := bson.D{{Name: "getMore", Value: int64(42)}}
+	bc.notifyStarted(7, "getMore", command)
+
+	if len(mon.started) != 1 {
+		t.Fatalf("expected exactly one Started event, got %d", len(mon.started))
+	}
+	got := mon.started[0]
+	if got.CommandName != "getMore" || got.RequestID != 7 || got.Database != "testdb" {
+		t.Fatalf("unexpected Started event: %+v", got)
+	}
+
+	var decoded struct {
+		GetMore int64 `bson:"getMore"`
+	}
+	if err := bson.Unmarshal(got.Command.Data, &decoded); err != nil {
+		t.Fatalf("unmarshal command: %v", err)
+	}
+	if decoded.GetMore != 42 {
+		t.Fatalf("expected the marshaled command to round-trip, got %+v", decoded)
+	}
+}
+
+func TestBatchCursor_NotifySucceeded(t *testing.T) {
+	mon := &recordingMonitor{}
+	bc := &BatchCursor{namespace: Namespace{DB: "testdb"}, monitor: mon}
+
+	reply := rawDoc(t, bson.D{{Name: "ok", Value: 1}})
+	bc.notifySucceeded(7, "getMore", reply)
+
+	if len(mon.succeeded) != 1 {
+		t.Fatalf("expected exactly one Succeeded event, got %d", len(mon.succeeded))
+	}
+	if mon.succeeded[0].RequestID != 7 || mon.succeeded[0].CommandName != "getMore" {
+		t.Fatalf("unexpected Succeeded event: %+v", mon.succeeded[0])
+	}
+}
+
+func TestBatchCursor_NotifyFailed(t *testing.T) {
+	mon := &recordingMonitor{}
+	bc := &BatchCursor{namespace: Namespace{DB: "testdb"}, monitor: mon}
+
+	err := errors.New("boom")
+	bc.notifyFailed(7, "killCursors", err)
+
+	if len(mon.failed) != 1 {
+		t.Fatalf("expected exactly one Failed event, got %d", len(mon.failed))
+	}
+	if mon.failed[0].Failure != err.Error() {
+		t.Fatalf("expected the Failed event to carry the error text, got %q", mon.failed[0].Failure)
+	}
+}
+
+func TestBatchCursor_NotifyMethods_NoopWithoutMonitor(t *testing.T) {
+	bc := &BatchCursor{namespace: Namespace{DB: "testdb"}}
+
+	// None of these should panic when no monitor is configured.
+	bc.notifyStarted(1, "getMore", bson.D{})
+	bc.notifySucceeded(1, "getMore", bson.Raw{})
+	bc.notifyFailed(1, "getMore", errors.New("boom"))
+}
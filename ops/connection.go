@@ -0,0 +1,55 @@
+package ops
+
+import (
+	"context"
+
+	"github.com/10gen/mongo-go-driver/core"
+)
+
+// Connection is a core.Connection checked out from a Server. Close returns it to the pool it came
+// from; callers must always defer it.
+type Connection interface {
+	core.Connection
+	Close() error
+
+	// Abort forcibly closes the real underlying socket, interrupting whatever read or write it is
+	// currently blocked on. Unlike Close, it always takes effect regardless of pinning, so
+	// cancelling a ctx blocked on a pinned cursor's in-flight getMore still unblocks it; callers
+	// that abort a connection must not go on to use it afterwards.
+	Abort() error
+}
+
+// Server acquires and releases connections for a single command at a time, replacing a cursor
+// pinning one physical core.Connection for its entire lifetime.
+type Server interface {
+	// Connection checks out a connection for the caller to use for one operation. The caller must
+	// call Close on the returned Connection when done with it.
+	Connection(ctx context.Context) (Connection, error)
+}
+
+// PinnedConnection wraps a Connection checked out once and reused for every command a cursor
+// issues over its lifetime, instead of being returned to the pool after each one. This is needed
+// for cursors created inside a transaction or against a load-balanced mongos, and for
+// tailable/exhaust cursors that must stay on the same physical connection.
+//
+// Close is a no-op so intermediate per-command `defer conn.Close()` calls don't release the
+// connection early; call Unpin to release it for real once the cursor itself is done.
+type PinnedConnection struct {
+	Connection
+}
+
+// Close is a no-op; use Unpin to release the underlying connection.
+func (p *PinnedConnection) Close() error {
+	return nil
+}
+
+// Abort forcibly closes the underlying connection even though it is pinned; this is the only way
+// to interrupt a blocked in-flight command on a pinned cursor, since Close is a no-op here.
+func (p *PinnedConnection) Abort() error {
+	return p.Connection.Abort()
+}
+
+// Unpin releases the underlying connection back to the pool it came from.
+func (p *PinnedConnection) Unpin() error {
+	return p.Connection.Close()
+}
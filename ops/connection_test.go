@@ -0,0 +1,155 @@
+package ops
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/10gen/mongo-go-driver/core"
+	"github.com/10gen/mongo-go-driver/core/msg"
+)
+
+type recordingServer struct {
+	fn func(ctx context.Context) (Connection, error)
+}
+
+func (s *recordingServer) Connection(ctx context.Context) (Connection, error) {
+	return s.fn(ctx)
+}
+
+func TestBatchCursor_acquireConnection_PinnedSkipsServer(t *testing.T) {
+	called := false
+	srv := &recordingServer{fn: func(ctx context.Context) (Connection, error) {
+		called = true
+		return nil, nil
+	}}
+	bc := &BatchCursor{server: srv, pinned: &PinnedConnection{}}
+
+	conn, err := bc.acquireConnection(context.Background())
+	if err != nil {
+		t.Fatalf("acquireConnection: %v", err)
+	}
+	if conn != bc.pinned {
+		t.Fatalf("expected the pinned connection to be returned")
+	}
+	if called {
+		t.Fatalf("expected server.Connection not to be called for a pinned cursor")
+	}
+}
+
+func TestBatchCursor_acquireConnection_NonPinnedUsesServer(t *testing.T) {
+	called := false
+	srv := &recordingServer{fn: func(ctx context.Context) (Connection, error) {
+		called = true
+		return nil, nil
+	}}
+	bc := &BatchCursor{server: srv}
+
+	if _, err := bc.acquireConnection(context.Background()); err != nil {
+		t.Fatalf("acquireConnection: %v", err)
+	}
+	if !called {
+		t.Fatalf("expected server.Connection to be called for a non-pinned cursor")
+	}
+}
+
+// TestBatchCursor_Close_IgnoresCallerCtxCancellation guards the best-effort killCursors guarantee:
+// Close must still try to clean up the cursor server-side even when called with an already
+// cancelled ctx, which is the common case when it runs from a defer after an iteration error.
+func TestBatchCursor_Close_IgnoresCallerCtxCancellation(t *testing.T) {
+	var gotCtx context.Context
+	srv := &recordingServer{fn: func(ctx context.Context) (Connection, error) {
+		gotCtx = ctx
+		return nil, errors.New("no connection in this test")
+	}}
+	bc := &BatchCursor{
+		namespace: Namespace{DB: "testdb", Collection: "coll"},
+		cursorId:  9,
+		server:    srv,
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	bc.Close(ctx)
+
+	if gotCtx == nil {
+		t.Fatalf("expected Close to attempt to acquire a connection for killCursors")
+	}
+	if gotCtx.Err() != nil {
+		t.Fatalf("expected Close to acquire its cleanup connection on a context that outlives the caller's cancelled one, got err=%v", gotCtx.Err())
+	}
+}
+
+// fakeCommandConnection stands in for a real core.Connection in executeCommand tests: embedding
+// core.Connection unimplemented is safe here because executeCommandFunc is stubbed out too, so
+// nothing ever calls through to the real interface methods.
+type fakeCommandConnection struct {
+	core.Connection
+	unblock     chan struct{}
+	closeCalled int32
+	abortCalled int32
+}
+
+func (c *fakeCommandConnection) Close() error {
+	atomic.AddInt32(&c.closeCalled, 1)
+	return nil
+}
+
+func (c *fakeCommandConnection) Abort() error {
+	atomic.AddInt32(&c.abortCalled, 1)
+	close(c.unblock)
+	return nil
+}
+
+// TestExecuteCommand_AbortsOnCtxCancellation guards against a regression this series hit twice:
+// d0112da's cancellation path called connection.Close(), which is a no-op on a PinnedConnection
+// and would leave a cancelled pinned getMore blocked forever; 3e7bb49 fixed it to call Abort
+// instead. This pins that fix down for both the pinned and non-pinned case.
+func TestExecuteCommand_AbortsOnCtxCancellation(t *testing.T) {
+	tests := []struct {
+		name string
+		wrap func(Connection) Connection
+	}{
+		{"non-pinned", func(c Connection) Connection { return c }},
+		{"pinned", func(c Connection) Connection { return &PinnedConnection{Connection: c} }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := &fakeCommandConnection{unblock: make(chan struct{})}
+			conn := tt.wrap(fake)
+
+			orig := executeCommandFunc
+			executeCommandFunc = func(core.Connection, *msg.Request, interface{}) error {
+				<-fake.unblock
+				return errors.New("interrupted")
+			}
+			defer func() { executeCommandFunc = orig }()
+
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+
+			done := make(chan error, 1)
+			go func() { done <- executeCommand(ctx, conn, nil, nil) }()
+
+			select {
+			case err := <-done:
+				if err != ctx.Err() {
+					t.Fatalf("expected ctx.Err() to be returned, got %v", err)
+				}
+			case <-time.After(time.Second):
+				t.Fatalf("executeCommand did not return promptly after ctx cancellation; Abort must not have unblocked it")
+			}
+
+			if atomic.LoadInt32(&fake.abortCalled) != 1 {
+				t.Fatalf("expected Abort to be called exactly once to unblock the in-flight command, got %d", fake.abortCalled)
+			}
+			if atomic.LoadInt32(&fake.closeCalled) != 0 {
+				t.Fatalf("expected executeCommand not to call Close itself (that's the caller's job via defer), got %d", fake.closeCalled)
+			}
+		})
+	}
+}
@@ -1,166 +1,516 @@
 package ops
 
 import (
+	"context"
+
 	"github.com/10gen/mongo-go-driver/core"
 	"github.com/10gen/mongo-go-driver/core/msg"
 	"gopkg.in/mgo.v2/bson"
 )
 
-// NewCursor creates a new cursor from the given cursor result.
-func NewCursor(cursorResult CursorResult, batchSize int32, connection core.Connection) (Cursor, error) {
-	namespace := cursorResult.Namespace()
-	if err := namespace.validate(); err != nil {
+// NewCursor creates a new document cursor from the given cursor result, decoding documents with
+// DefaultRegistry. opts may be nil.
+func NewCursor(ctx context.Context, cursorResult CursorResult, server Server, opts *CursorOptions) (Cursor, error) {
+	return NewCursorWithRegistry(ctx, cursorResult, server, DefaultRegistry, opts)
+}
+
+// NewCursorWithRegistry creates a new document cursor from the given cursor result, decoding
+// documents with registry instead of DefaultRegistry. This lets callers that need custom decode
+// behavior (e.g. a different struct tag convention or type mapping) reuse the same batch-fetching
+// machinery as NewCursor. opts may be nil.
+func NewCursorWithRegistry(ctx context.Context, cursorResult CursorResult, server Server, registry Registry, opts *CursorOptions) (Cursor, error) {
+	bc, err := NewBatchCursor(ctx, cursorResult, server, opts)
+	if err != nil {
 		return nil, err
 	}
 
 	return &cursorImpl{
-		namespace:    cursorResult.Namespace(),
-		batchSize:    batchSize,
-		current:      0,
-		currentBatch: cursorResult.InitialBatch(),
-		cursorId:     cursorResult.CursorId(),
-		connection:   connection,
+		bc:       bc,
+		registry: registry,
 	}, nil
 }
 
+// CursorOptions configures the commands a BatchCursor (and the document Cursor built on top of
+// it) issues.
+type CursorOptions struct {
+	// Monitor, if set, is notified of every getMore and killCursors command the cursor issues.
+	Monitor CommandMonitor
+
+	// BatchSize is forwarded as the batchSize of each getMore. Zero leaves it up to the server.
+	BatchSize int32
+
+	// MaxTimeMS bounds how long each getMore is allowed to block on the server, and is required
+	// for awaitData tailable cursors to avoid blocking indefinitely.
+	MaxTimeMS int64
+
+	// Comment is forwarded on each getMore so log and profiler entries for the follow-up commands
+	// can be correlated with the find/aggregate that created the cursor.
+	Comment interface{}
+
+	// Pinned, if true, checks out a single connection from Server for the cursor's entire
+	// lifetime instead of per command. Required for cursors created inside a transaction or
+	// against a load-balanced mongos, and for tailable/exhaust cursors.
+	Pinned bool
+}
+
+// CommandMonitor receives APM-style notifications about commands a cursor issues, matching the
+// event model used by modern drivers. Implementations must not block, since callbacks run
+// synchronously on the goroutine issuing the command.
+type CommandMonitor interface {
+	Started(*CommandStartedEvent)
+	Succeeded(*CommandSucceededEvent)
+	Failed(*CommandFailedEvent)
+}
+
+// CommandStartedEvent is published immediately before a command is sent to the server.
+type CommandStartedEvent struct {
+	CommandName string
+	RequestID   int64
+	Database    string
+	Command     bson.Raw
+}
+
+// CommandSucceededEvent is published when a command's reply has been received and the server
+// reported it as successful.
+type CommandSucceededEvent struct {
+	CommandName string
+	RequestID   int64
+	Database    string
+	Reply       bson.Raw
+}
+
+// CommandFailedEvent is published when a command could not be executed or the server reported it
+// as failed.
+type CommandFailedEvent struct {
+	CommandName string
+	RequestID   int64
+	Database    string
+	Failure     string
+}
+
+// Registry decodes a raw BSON document into result. It is the configurable decode strategy used
+// by document cursors built on top of a BatchCursor.
+type Registry interface {
+	Decode(raw bson.Raw, result interface{}) error
+}
+
+// DefaultRegistry decodes documents with the rules of the bson package, the same behavior the
+// Cursor interface has always had.
+var DefaultRegistry Registry = defaultRegistry{}
+
+type defaultRegistry struct{}
+
+func (defaultRegistry) Decode(raw bson.Raw, result interface{}) error {
+	return bson.Unmarshal(raw.Data, result)
+}
+
 // Cursor instances iterate a stream of documents. Each document is decoded into the result according to the rules of
 // the bson package.  A typical usage of the Cursor interface would be:
 //
 //      cursor := ...    // get a cursor from some operation
 //      var doc bson.D
-//      for cursor.Next(&doc) {
+//      for cursor.Next(ctx, &doc) {
 //              fmt.Println(doc)
-//      err := cursor.Close()
+//      err := cursor.Close(ctx)
 type Cursor interface {
 	// Get the next result from the cursor.
 	// Returns true if there were no errors and there is a next result.
-	Next(result interface{}) bool
+	// Blocks through successive getMore calls until a document is returned, the cursor is
+	// exhausted, or ctx is done.
+	Next(ctx context.Context, result interface{}) bool
+
+	// TryNext attempts to get the next result from the cursor without blocking on further
+	// getMore calls. If the current batch is drained and the server reported a non-zero cursor
+	// id, it issues at most one getMore and returns false if that batch is empty too, making it
+	// suitable for tailable and change-stream style iteration where an empty batch does not mean
+	// the cursor is exhausted.
+	TryNext(ctx context.Context, result interface{}) bool
 
 	// Returns the error status of the cursor
 	Err() error
 
 	// Close the cursor.  Ordinarily this is a no-op as the server closes the cursor when it is exhausted.
 	// Returns the error status of this cursor so that clients do not have to call Err() separately
-	Close() error
+	Close(ctx context.Context) error
 }
 
+// cursorImpl is a document-level Cursor built on top of a BatchCursor: it decodes one document at
+// a time out of whatever batch the BatchCursor currently holds, fetching a new batch only once the
+// current one is exhausted.
 type cursorImpl struct {
-	namespace    Namespace
-	batchSize    int32
-	current      int
-	currentBatch []bson.Raw
-	cursorId     int64
-	err          error
-	connection   core.Connection // TODO: missing abstraction.  Shouldn't require a connection here, but just a way to acquire and release one
-}
-
-func (c *cursorImpl) Next(result interface{}) bool {
-	found := c.getNextFromCurrentBatch(result)
-	if found {
+	bc       *BatchCursor
+	registry Registry
+	current  int
+	err      error
+}
+
+func (c *cursorImpl) Next(ctx context.Context, result interface{}) bool {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	for {
+		if c.decodeNext(result) {
+			return true
+		}
+		if c.err != nil {
+			return false
+		}
+		if !c.bc.NextBatch(ctx) {
+			c.err = c.bc.Err()
+			return false
+		}
+		c.current = 0
+	}
+}
+
+func (c *cursorImpl) TryNext(ctx context.Context, result interface{}) bool {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	if c.decodeNext(result) {
 		return true
 	}
 	if c.err != nil {
 		return false
 	}
+	if !c.bc.NextBatch(ctx) {
+		c.err = c.bc.Err()
+		return false
+	}
+	c.current = 0
 
-	c.getMore()
+	return c.decodeNext(result)
+}
+
+func (c *cursorImpl) Err() error {
 	if c.err != nil {
+		return c.err
+	}
+	return c.bc.Err()
+}
+
+func (c *cursorImpl) Close(ctx context.Context) error {
+	return c.bc.Close(ctx)
+}
+
+func (c *cursorImpl) decodeNext(result interface{}) bool {
+	batch := c.bc.Batch()
+	if c.current < len(batch) {
+		if err := c.registry.Decode(batch[c.current], result); err != nil {
+			c.err = err
+			return false
+		}
+		c.current++
+		return true
+	}
+	return false
+}
+
+// BatchCursor is the lower-level cursor that NewCursor and NewCursorWithRegistry build on. It
+// speaks getMore/killCursors and exposes the server's batches as raw BSON, without decoding, so
+// bulk consumers (aggregation pipelines, export tools, change-stream resumers) can avoid the
+// per-document unmarshal cost a document Cursor forces.
+type BatchCursor struct {
+	namespace            Namespace
+	batchSize            int32
+	maxTimeMS            int64
+	comment              interface{}
+	currentBatch         []bson.Raw
+	queuedBatch          []bson.Raw
+	cursorId             int64
+	postBatchResumeToken bson.Raw
+	err                  error
+	server               Server
+	pinned               *PinnedConnection
+	monitor              CommandMonitor
+}
+
+// NewBatchCursor creates a new BatchCursor from the given cursor result, acquiring a pinned
+// connection up front if opts.Pinned is set. opts may be nil.
+func NewBatchCursor(ctx context.Context, cursorResult CursorResult, server Server, opts *CursorOptions) (*BatchCursor, error) {
+	namespace := cursorResult.Namespace()
+	if err := namespace.validate(); err != nil {
+		return nil, err
+	}
+
+	bc := &BatchCursor{
+		namespace:   namespace,
+		queuedBatch: cursorResult.InitialBatch(),
+		cursorId:    cursorResult.CursorId(),
+		server:      server,
+	}
+	if opts != nil {
+		bc.monitor = opts.Monitor
+		bc.batchSize = opts.BatchSize
+		bc.maxTimeMS = opts.MaxTimeMS
+		bc.comment = opts.Comment
+
+		if opts.Pinned {
+			conn, err := server.Connection(ctx)
+			if err != nil {
+				return nil, err
+			}
+			bc.pinned = &PinnedConnection{Connection: conn}
+		}
+	}
+
+	return bc, nil
+}
+
+// acquireConnection returns the cursor's pinned connection if it has one, otherwise checks one out
+// of server for the caller to use for a single command and Close() when done.
+func (bc *BatchCursor) acquireConnection(ctx context.Context) (Connection, error) {
+	if bc.pinned != nil {
+		return bc.pinned, nil
+	}
+	return bc.server.Connection(ctx)
+}
+
+// Batch returns the batch most recently fetched by NextBatch. It is valid until the next call to
+// NextBatch or Close.
+func (bc *BatchCursor) Batch() []bson.Raw {
+	return bc.currentBatch
+}
+
+// NextBatch advances the cursor to the next batch, issuing a getMore if the initial batch has
+// already been consumed. It returns false once the cursor is exhausted or an error has occurred;
+// callers should check Err() to distinguish the two.
+func (bc *BatchCursor) NextBatch(ctx context.Context) bool {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	if bc.queuedBatch != nil {
+		bc.currentBatch, bc.queuedBatch = bc.queuedBatch, nil
+		return true
+	}
+
+	if bc.err != nil {
+		return false
+	}
+	if bc.cursorId == 0 {
+		bc.currentBatch = nil
 		return false
 	}
 
-	return c.getNextFromCurrentBatch(result)
+	bc.getMore(ctx)
+	return bc.err == nil
 }
 
-func (c *cursorImpl) Err() error {
-	return c.err
+// PostBatchResumeToken returns the postBatchResumeToken from the most recent getMore response, or
+// a zero bson.Raw if the server didn't include one (e.g. the command wasn't a $changeStream
+// aggregation).
+func (bc *BatchCursor) PostBatchResumeToken() bson.Raw {
+	return bc.postBatchResumeToken
 }
 
-func (c *cursorImpl) Close() error {
-	c.currentBatch = nil
+// Err returns the error status of the cursor.
+func (bc *BatchCursor) Err() error {
+	return bc.err
+}
 
-	if c.cursorId == 0 {
-		return c.err
+// Close closes the cursor. Ordinarily this is a no-op as the server closes the cursor when it is
+// exhausted. Returns the error status of this cursor so that clients do not have to call Err()
+// separately.
+func (bc *BatchCursor) Close(ctx context.Context) error {
+	bc.currentBatch = nil
+
+	if bc.cursorId == 0 {
+		return bc.err
 	}
 
 	killCursorsCommand := struct {
 		Collection string  `bson:"killCursors"`
 		Cursors    []int64 `bson:"cursors"`
 	}{
-		Collection: c.namespace.Collection,
-		Cursors:    []int64{c.cursorId},
+		Collection: bc.namespace.Collection,
+		Cursors:    []int64{bc.cursorId},
 	}
 
+	requestID := msg.NextRequestID()
 	killCursorsRequest := msg.NewCommand(
-		msg.NextRequestID(),
-		c.namespace.DB,
+		requestID,
+		bc.namespace.DB,
 		false,
 		killCursorsCommand,
 	)
 
-	err := core.ExecuteCommand(c.connection, killCursorsRequest, &bson.D{})
-	if err == nil {
-		c.cursorId = 0
-	} else if c.err == nil {
-		c.err = err
+	bc.notifyStarted(requestID, "killCursors", killCursorsCommand)
+
+	// killCursors is best-effort: it runs on its own context rather than the caller's, so a cursor
+	// whose ctx is already cancelled or expired (the common case when Close runs from a defer after
+	// an iteration error) still gets its server-side resources cleaned up, on a fresh connection
+	// or, for a pinned cursor, on its pinned one.
+	cleanupCtx := context.Background()
+
+	conn, err := bc.acquireConnection(cleanupCtx)
+	if err != nil {
+		bc.notifyFailed(requestID, "killCursors", err)
+		if bc.err == nil {
+			bc.err = err
+		}
+		return bc.err
 	}
+	defer conn.Close()
 
-	return c.err
-}
+	var reply bson.Raw
+	err = executeCommand(cleanupCtx, conn, killCursorsRequest, &reply)
+	if err == nil {
+		bc.cursorId = 0
+		bc.notifySucceeded(requestID, "killCursors", reply)
+	} else {
+		bc.notifyFailed(requestID, "killCursors", err)
+		if bc.err == nil {
+			bc.err = err
+		}
+	}
 
-func (c *cursorImpl) getNextFromCurrentBatch(result interface{}) bool {
-	if c.current < len(c.currentBatch) {
-		err := bson.Unmarshal(c.currentBatch[c.current].Data, result)
-		if err != nil {
-			c.err = err
-			return false
+	if bc.pinned != nil {
+		if unpinErr := bc.pinned.Unpin(); unpinErr != nil && bc.err == nil {
+			bc.err = unpinErr
 		}
-		c.current++
-		return true
 	}
-	return false
+
+	return bc.err
 }
 
-func (c *cursorImpl) getMore() {
-	c.currentBatch = nil
-	c.current = 0
+func (bc *BatchCursor) getMore(ctx context.Context) {
+	bc.currentBatch = nil
 
-	if c.cursorId == 0 {
+	if bc.cursorId == 0 {
 		return
 	}
 
 	getMoreCommand := struct {
-		CursorId   int64  `bson:"getMore"`
-		Collection string `bson:"collection"`
-		BatchSize  int32  `bson:"batchSize,omitempty"`
+		CursorId   int64       `bson:"getMore"`
+		Collection string      `bson:"collection"`
+		BatchSize  int32       `bson:"batchSize,omitempty"`
+		MaxTimeMS  int64       `bson:"maxTimeMS,omitempty"`
+		Comment    interface{} `bson:"comment,omitempty"`
 	}{
-		CursorId:   c.cursorId,
-		Collection: c.namespace.Collection,
+		CursorId:   bc.cursorId,
+		Collection: bc.namespace.Collection,
+		MaxTimeMS:  bc.maxTimeMS,
+		Comment:    bc.comment,
 	}
-	if c.batchSize != 0 {
-		getMoreCommand.BatchSize = c.batchSize
+	if bc.batchSize != 0 {
+		getMoreCommand.BatchSize = bc.batchSize
 	}
+	requestID := msg.NextRequestID()
 	getMoreRequest := msg.NewCommand(
-		msg.NextRequestID(),
-		c.namespace.DB,
+		requestID,
+		bc.namespace.DB,
 		false,
 		getMoreCommand,
 	)
 
+	bc.notifyStarted(requestID, "getMore", getMoreCommand)
+
+	conn, err := bc.acquireConnection(ctx)
+	if err != nil {
+		bc.notifyFailed(requestID, "getMore", err)
+		bc.err = err
+		return
+	}
+	defer conn.Close()
+
+	var reply bson.Raw
+	if err := executeCommand(ctx, conn, getMoreRequest, &reply); err != nil {
+		bc.notifyFailed(requestID, "getMore", err)
+		bc.err = err
+		return
+	}
+
 	var response struct {
 		OK     bool `bson:"ok"`
 		Cursor struct {
-			       NextBatch []bson.Raw `bson:"nextBatch"`
-			       NS        string     `bson:"ns"`
-			       ID        int64      `bson:"id"`
-		       } `bson:"cursor"`
+			NextBatch            []bson.Raw `bson:"nextBatch"`
+			NS                   string     `bson:"ns"`
+			ID                   int64      `bson:"id"`
+			PostBatchResumeToken bson.Raw   `bson:"postBatchResumeToken"`
+		} `bson:"cursor"`
 	}
+	if err := bson.Unmarshal(reply.Data, &response); err != nil {
+		bc.notifyFailed(requestID, "getMore", err)
+		bc.err = err
+		return
+	}
+
+	bc.notifySucceeded(requestID, "getMore", reply)
+
+	bc.cursorId = response.Cursor.ID
+	bc.currentBatch = response.Cursor.NextBatch
+	bc.postBatchResumeToken = response.Cursor.PostBatchResumeToken
+}
 
-	err := core.ExecuteCommand(c.connection, getMoreRequest, &response)
+func (bc *BatchCursor) notifyStarted(requestID int64, commandName string, command interface{}) {
+	if bc.monitor == nil {
+		return
+	}
+	data, err := bson.Marshal(command)
 	if err != nil {
-		c.err = err
 		return
 	}
+	bc.monitor.Started(&CommandStartedEvent{
+		CommandName: commandName,
+		RequestID:   requestID,
+		Database:    bc.namespace.DB,
+		Command:     bson.Raw{Kind: 0x03, Data: data},
+	})
+}
 
-	c.cursorId = response.Cursor.ID
-	c.currentBatch = response.Cursor.NextBatch
-}
\ No newline at end of file
+func (bc *BatchCursor) notifySucceeded(requestID int64, commandName string, reply bson.Raw) {
+	if bc.monitor == nil {
+		return
+	}
+	bc.monitor.Succeeded(&CommandSucceededEvent{
+		CommandName: commandName,
+		RequestID:   requestID,
+		Database:    bc.namespace.DB,
+		Reply:       reply,
+	})
+}
+
+func (bc *BatchCursor) notifyFailed(requestID int64, commandName string, err error) {
+	if bc.monitor == nil {
+		return
+	}
+	bc.monitor.Failed(&CommandFailedEvent{
+		CommandName: commandName,
+		RequestID:   requestID,
+		Database:    bc.namespace.DB,
+		Failure:     err.Error(),
+	})
+}
+
+// executeCommandFunc is core.ExecuteCommand; tests substitute it to simulate a blocked in-flight
+// command without a real core.Connection.
+var executeCommandFunc = core.ExecuteCommand
+
+// executeCommand runs req against connection and decodes the reply into result, aborting early
+// if ctx is cancelled or its deadline is exceeded before core.ExecuteCommand returns.
+func executeCommand(ctx context.Context, connection Connection, req *msg.Request, result interface{}) error {
+	errChan := make(chan error, 1)
+	go func() {
+		errChan <- executeCommandFunc(connection, req, result)
+	}()
+
+	select {
+	case err := <-errChan:
+		return err
+	case <-ctx.Done():
+	}
+
+	// ctx fired while core.ExecuteCommand was still in flight. Abort unblocks whatever read or
+	// write it is stuck on; Close won't do here, since it is a no-op for a pinned connection and
+	// would otherwise leave that in-flight getMore blocked forever. Without this, the goroutine
+	// above keeps running after we return, and the caller's deferred Close would hand connection
+	// back to the pool while that orphaned goroutine is still on the wire with it, corrupting
+	// whatever the pool hands it to next. Wait for the goroutine to actually finish before
+	// returning.
+	connection.Abort()
+	<-errChan
+	return ctx.Err()
+}
@@ -0,0 +1,108 @@
+package ops
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+type fakeCursorResult struct {
+	ns           Namespace
+	initialBatch []bson.Raw
+	cursorId     int64
+}
+
+func (r *fakeCursorResult) Namespace() Namespace     { return r.ns }
+func (r *fakeCursorResult) InitialBatch() []bson.Raw { return r.initialBatch }
+func (r *fakeCursorResult) CursorId() int64          { return r.cursorId }
+
+// errorServer always fails to hand out a connection, so tests can drive BatchCursor up to (but
+// never through) the point where it would need a real core.Connection.
+type errorServer struct {
+	err error
+}
+
+func (s *errorServer) Connection(ctx context.Context) (Connection, error) {
+	return nil, s.err
+}
+
+func TestNewBatchCursor_CopiesCursorOptionsOntoCursor(t *testing.T) {
+	opts := &CursorOptions{BatchSize: 100, MaxTimeMS: 5000, Comment: "audit-123"}
+	result := &fakeCursorResult{ns: Namespace{DB: "testdb", Collection: "coll"}, cursorId: 9}
+
+	bc, err := NewBatchCursor(context.Background(), result, nil, opts)
+	if err != nil {
+		t.Fatalf("NewBatchCursor: %v", err)
+	}
+	if bc.batchSize != 100 || bc.maxTimeMS != 5000 || bc.comment != "audit-123" {
+		t.Fatalf("expected CursorOptions to be copied onto the BatchCursor, got batchSize=%d maxTimeMS=%d comment=%v",
+			bc.batchSize, bc.maxTimeMS, bc.comment)
+	}
+}
+
+func TestNewBatchCursor_NilOptionsLeavesZeroValues(t *testing.T) {
+	result := &fakeCursorResult{ns: Namespace{DB: "testdb", Collection: "coll"}}
+
+	bc, err := NewBatchCursor(context.Background(), result, nil, nil)
+	if err != nil {
+		t.Fatalf("NewBatchCursor: %v", err)
+	}
+	if bc.batchSize != 0 || bc.maxTimeMS != 0 || bc.comment != nil {
+		t.Fatalf("expected zero values with nil opts, got %+v", bc)
+	}
+}
+
+func TestBatchCursorGetMore_ThreadsCursorOptionsIntoCommand(t *testing.T) {
+	mon := &recordingMonitor{}
+	bc := &BatchCursor{
+		namespace: Namespace{DB: "testdb", Collection: "coll"},
+		cursorId:  9,
+		batchSize: 50,
+		maxTimeMS: 1000,
+		comment:   "audit",
+		server:    &errorServer{err: errors.New("no connection in this test")},
+		monitor:   mon,
+	}
+
+	bc.getMore(context.Background())
+
+	if len(mon.started) != 1 {
+		t.Fatalf("expected exactly one Started event, got %d", len(mon.started))
+	}
+
+	var decoded struct {
+		BatchSize int32       `bson:"batchSize"`
+		MaxTimeMS int64       `bson:"maxTimeMS"`
+		Comment   interface{} `bson:"comment"`
+	}
+	if err := bson.Unmarshal(mon.started[0].Command.Data, &decoded); err != nil {
+		t.Fatalf("unmarshal command: %v", err)
+	}
+	if decoded.BatchSize != 50 || decoded.MaxTimeMS != 1000 || decoded.Comment != "audit" {
+		t.Fatalf("expected getMore to carry batchSize/maxTimeMS/comment, got %+v", decoded)
+	}
+}
+
+func TestBatchCursorGetMore_OmitsZeroBatchSize(t *testing.T) {
+	mon := &recordingMonitor{}
+	bc := &BatchCursor{
+		namespace: Namespace{DB: "testdb", Collection: "coll"},
+		cursorId:  9,
+		server:    &errorServer{err: errors.New("no connection in this test")},
+		monitor:   mon,
+	}
+
+	bc.getMore(context.Background())
+
+	var decoded bson.D
+	if err := bson.Unmarshal(mon.started[0].Command.Data, &decoded); err != nil {
+		t.Fatalf("unmarshal command: %v", err)
+	}
+	for _, elem := range decoded {
+		if elem.Name == "batchSize" {
+			t.Fatalf("expected batchSize to be omitted when zero, got %+v", decoded)
+		}
+	}
+}
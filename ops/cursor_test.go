@@ -0,0 +1,98 @@
+package ops
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+// failingRegistry lets tests force a decode error without needing a malformed bson.Raw.
+type failingRegistry struct {
+	err error
+}
+
+func (r *failingRegistry) Decode(raw bson.Raw, result interface{}) error {
+	return r.err
+}
+
+func rawDoc(t *testing.T, doc bson.D) bson.Raw {
+	t.Helper()
+	data, err := bson.Marshal(doc)
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+	return bson.Raw{Kind: 0x03, Data: data}
+}
+
+func TestCursorNext_LoopsUntilDocumentThenExhausts(t *testing.T) {
+	bc := &BatchCursor{queuedBatch: []bson.Raw{rawDoc(t, bson.D{{Name: "x", Value: 1}})}}
+	c := &cursorImpl{bc: bc, registry: DefaultRegistry}
+
+	var doc bson.D
+	if !c.Next(context.Background(), &doc) {
+		t.Fatalf("expected first Next to return a document, err=%v", c.Err())
+	}
+	if c.Next(context.Background(), &doc) {
+		t.Fatalf("expected second Next to exhaust the cursor")
+	}
+	if c.Err() != nil {
+		t.Fatalf("exhausting the cursor is not an error: %v", c.Err())
+	}
+}
+
+// TestCursorTryNext_DoesNotBlockOnEmptyBatch is the thing Next and TryNext disagree on: Next would
+// keep issuing getMore until it found a document or the cursor died, which for a tailable cursor
+// with no new data yet would block forever. TryNext must give up after the one batch it has.
+func TestCursorTryNext_DoesNotBlockOnEmptyBatch(t *testing.T) {
+	bc := &BatchCursor{queuedBatch: []bson.Raw{}}
+	c := &cursorImpl{bc: bc, registry: DefaultRegistry}
+
+	var doc bson.D
+	if c.TryNext(context.Background(), &doc) {
+		t.Fatalf("TryNext should not find a document in an empty batch")
+	}
+	if c.Err() != nil {
+		t.Fatalf("an empty batch with cursorId 0 is exhaustion, not an error: %v", c.Err())
+	}
+}
+
+// TestCursorTryNext_IssuesExactlyOneGetMoreWhenBatchDrained is the case
+// TestCursorTryNext_DoesNotBlockOnEmptyBatch doesn't reach: a nonzero cursorId with no queued
+// batch. Unlike Next, which would keep retrying, TryNext must attempt a single getMore round trip
+// (bounded by MaxTimeMS on a tailable/change-stream cursor) and return, not refuse to ask the
+// server at all.
+func TestCursorTryNext_IssuesExactlyOneGetMoreWhenBatchDrained(t *testing.T) {
+	attempts := 0
+	srv := &recordingServer{fn: func(ctx context.Context) (Connection, error) {
+		attempts++
+		return nil, errors.New("no connection in this test")
+	}}
+	bc := &BatchCursor{currentBatch: []bson.Raw{}, cursorId: 42, server: srv}
+	c := &cursorImpl{bc: bc, registry: DefaultRegistry}
+
+	var doc bson.D
+	if c.TryNext(context.Background(), &doc) {
+		t.Fatalf("expected TryNext to find nothing when the getMore fails")
+	}
+	if c.Err() == nil {
+		t.Fatalf("expected the getMore failure to surface through Err()")
+	}
+	if attempts != 1 {
+		t.Fatalf("expected TryNext to attempt exactly one getMore round trip, got %d", attempts)
+	}
+}
+
+func TestCursorNext_SurfacesDecodeError(t *testing.T) {
+	bc := &BatchCursor{queuedBatch: []bson.Raw{rawDoc(t, bson.D{{Name: "x", Value: 1}})}}
+	c := &cursorImpl{bc: bc, registry: &failingRegistry{err: errors.New("boom")}}
+
+	var doc bson.D
+	if c.Next(context.Background(), &doc) {
+		t.Fatalf("expected Next to fail on decode error")
+	}
+	if c.Err() == nil {
+		t.Fatalf("expected Err() to report the decode failure")
+	}
+}